@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"gitlab.com/deqode/tokenholder-management-lambda/blockchain/contracts"
+	"gitlab.com/deqode/tokenholder-management-lambda/config"
+)
+
+// errEstimateOnly stops a voucher txn right after its gas has been estimated
+var errEstimateOnly = errors.New("txn intercepted after gas estimation")
+
+// auditBackend is the chain access needed by the audit, satisfied by both
+// ethclient and the simulated backend
+type auditBackend interface {
+	bind.ContractBackend
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// tokenHolderProcessor is the part of the token holder contract binding used
+// by the processing key
+type tokenHolderProcessor interface {
+	PROCESSORROLE(opts *bind.CallOpts) ([32]byte, error)
+	HasRole(opts *bind.CallOpts, role [32]byte, account common.Address) (bool, error)
+	SellVouchers(opts *bind.TransactOpts) (*ethtypes.Transaction, error)
+	BuyVouchers(opts *bind.TransactOpts) (*ethtypes.Transaction, error)
+}
+
+// auditReport collects the failures found by an audit run
+type auditReport struct {
+	failures []string
+}
+
+func (r *auditReport) fail(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Printf("audit failure: %s\n", msg)
+	r.failures = append(r.failures, msg)
+}
+
+func (r *auditReport) summary(at time.Time) string {
+	return fmt.Sprintf("Token holder audit at %s found %d issue(s):\n- %s", at, len(r.failures), strings.Join(r.failures, "\n- "))
+}
+
+// isAuditEvent reports whether the event was triggered by the audit rule
+func isAuditEvent(event events.CloudWatchEvent, auditRuleName string) bool {
+	if auditRuleName == "" {
+		return false
+	}
+	for _, resource := range event.Resources {
+		if strings.HasSuffix(resource, ":rule/"+auditRuleName) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleAudit verifies that the keys and permissions the token holder event
+// depends on are still intact, and alerts on any drift. No funds are moved.
+func HandleAudit(ctx context.Context, event events.CloudWatchEvent) (string, error) {
+	report := &auditReport{}
+
+	// IAM/KMS permissions: parameters must still be readable and decryptable
+	sess, err := session.NewSession(aws.NewConfig())
+	if err != nil {
+		report.fail("Failed to create new session with error : %s", err)
+	}
+	path, found := os.LookupEnv("SSM_PS_PATH")
+	if !found {
+		report.fail("SSM_PS_PATH is not set, unable to verify parameter store permissions")
+	} else if sess != nil {
+		if _, err := getParameters(sess, path); err != nil {
+			report.fail("Failed to get and decrypt parameters under %s with error : %s", path, err)
+		}
+	}
+
+	// Checks only run when the config they depend on parsed cleanly
+	configErr := config.Parse()
+	if configErr != nil {
+		report.fail("Unable to parse environment variables with error : %s", configErr)
+	}
+	auditConfigErr := config.ParseAudit()
+	if auditConfigErr != nil {
+		report.fail("Unable to parse audit environment variables with error : %s", auditConfigErr)
+	}
+
+	if configErr == nil {
+		ethClient, err := ethclient.Dial(config.EthereumJSONRPCURL)
+		if err != nil {
+			report.fail("Failed to call ethereum client with error : %s", err)
+		} else {
+			if deqodeVaultPrivateKey, err := crypto.HexToECDSA(config.deqodeVaultPrivateKey); err != nil {
+				report.fail("Failed to parse ecdsa private key from deqode vault private key sting with error : %s", err)
+			} else if auditConfigErr == nil {
+				auditVaultKey(ctx, ethClient, deqodeVaultPrivateKey, common.HexToAddress(config.ExpectedVaultAddress), report)
+			}
+
+			tokenHolderContractAddress := common.HexToAddress(config.TokenHolderContractAddress)
+			tokenHolderContract, err := contracts.NewdeqodeTokenHolderProgramContract(tokenHolderContractAddress, ethClient)
+			if err != nil {
+				report.fail("Failed to load token holder contract for address %s with error : %s", tokenHolderContractAddress.String(), err)
+			} else if tokenHolderProcessingKey, err := crypto.HexToECDSA(config.TokenHolderProcessingKey); err != nil {
+				report.fail("Failed to parse ecdsa private key from token holder processing key string with error : %s", err)
+			} else {
+				auditProcessingKey(ctx, ethClient, tokenHolderContract, tokenHolderProcessingKey, report)
+			}
+		}
+	}
+
+	return reportAudit(report, event.Time, config.AuditAlertTopicARN, func(topicArn, summary string) error {
+		if sess == nil {
+			return fmt.Errorf("no aws session available")
+		}
+		return publishAlert(sess, topicArn, summary)
+	})
+}
+
+// reportAudit publishes the audit failures, if any, to the alert topic.
+// An error is only returned when the alert could not be published, since
+// Lambda retries failed async invocations and would alert again.
+func reportAudit(report *auditReport, at time.Time, topicArn string, publish func(topicArn, summary string) error) (string, error) {
+	if len(report.failures) == 0 {
+		return fmt.Sprintf("Token holder audit successfully passed at %s!", at), nil
+	}
+
+	summary := report.summary(at)
+	if topicArn == "" {
+		log.Printf("error: AUDIT_ALERT_TOPIC_ARN is not set, audit failures are only reported as function error\n")
+		return formatError("%s", summary)
+	}
+	if err := publish(topicArn, summary); err != nil {
+		log.Printf("error: failed to publish audit alert, %s\n", err)
+		return formatError("%s", summary)
+	}
+	return fmt.Sprintf("Token holder audit alert published at %s", at), nil
+}
+
+// auditVaultKey checks that the vault private key still controls the expected
+// vault address and that its balance can be read
+func auditVaultKey(ctx context.Context, backend auditBackend, deqodeVaultPrivateKey *ecdsa.PrivateKey, expected common.Address, report *auditReport) {
+	deqodeVaultAddress := crypto.PubkeyToAddress(deqodeVaultPrivateKey.PublicKey)
+	if deqodeVaultAddress != expected {
+		report.fail("Vault private key controls %s instead of expected address %s", deqodeVaultAddress.Hex(), expected.Hex())
+	}
+
+	if _, err := backend.BalanceAt(ctx, deqodeVaultAddress, nil); err != nil {
+		report.fail("Failed to get balance for vault with error : %s", err)
+	}
+}
+
+// auditProcessingKey checks that the processing key still holds the processor
+// role, that sell and buy vouchers would currently succeed from it, and that
+// it can pay for both
+func auditProcessingKey(ctx context.Context, backend auditBackend, tokenHolderContract tokenHolderProcessor, tokenHolderProcessingKey *ecdsa.PrivateKey, report *auditReport) {
+	processingAddress := crypto.PubkeyToAddress(tokenHolderProcessingKey.PublicKey)
+	callOpts := &bind.CallOpts{Context: ctx}
+
+	// Role id is read from the contract so it always matches what buy/sell check
+	role, err := tokenHolderContract.PROCESSORROLE(callOpts)
+	if err != nil {
+		report.fail("Failed to get processor role from token holder contract with error : %s", err)
+	} else {
+		hasRole, err := tokenHolderContract.HasRole(callOpts, role, processingAddress)
+		if err != nil {
+			report.fail("Failed to check processor role of processing key %s with error : %s", processingAddress.Hex(), err)
+		} else if !hasRole {
+			report.fail("Processing key %s no longer holds processor role on token holder contract", processingAddress.Hex())
+		}
+	}
+
+	gasPrice, err := backend.SuggestGasPrice(ctx)
+	if err != nil {
+		report.fail("Failed to get gas price from ethereum client with error : %s", err)
+		return
+	}
+
+	sellCost, err := estimateVoucherCost(ctx, tokenHolderContract.SellVouchers, tokenHolderProcessingKey, gasPrice)
+	if err != nil {
+		report.fail("Sell vouchers from processing key %s would fail with error : %s", processingAddress.Hex(), err)
+	}
+	buyCost, buyErr := estimateVoucherCost(ctx, tokenHolderContract.BuyVouchers, tokenHolderProcessingKey, gasPrice)
+	if buyErr != nil {
+		report.fail("Buy vouchers from processing key %s would fail with error : %s", processingAddress.Hex(), buyErr)
+	}
+	if err != nil || buyErr != nil {
+		return
+	}
+
+	balance, err := backend.BalanceAt(ctx, processingAddress, nil)
+	if err != nil {
+		report.fail("Failed to get balance for processing key with error : %s", err)
+		return
+	}
+	// A shortfall would only show after vault funds were already transferred
+	if required := new(big.Int).Add(sellCost, buyCost); balance.Cmp(required) < 0 {
+		report.fail("Processing key %s balance %s is below estimated sell and buy vouchers txn fees %s", processingAddress.Hex(), balance, required)
+	}
+}
+
+// estimateVoucherCost estimates the fee of a voucher txn sent by key without
+// sending it, by stopping the binding at the signing step
+func estimateVoucherCost(ctx context.Context, voucherTxn func(*bind.TransactOpts) (*ethtypes.Transaction, error), key *ecdsa.PrivateKey, gasPrice *big.Int) (*big.Int, error) {
+	var gas uint64
+	auth := bind.NewKeyedTransactor(key)
+	auth.Context = ctx
+	auth.GasPrice = gasPrice
+	auth.Signer = func(signer ethtypes.Signer, address common.Address, tx *ethtypes.Transaction) (*ethtypes.Transaction, error) {
+		gas = tx.Gas()
+		return nil, errEstimateOnly
+	}
+
+	_, err := voucherTxn(auth)
+	if err != errEstimateOnly {
+		if err == nil {
+			err = fmt.Errorf("txn was not intercepted before sending")
+		}
+		return nil, err
+	}
+	return new(big.Int).Mul(new(big.Int).SetUint64(gas), gasPrice), nil
+}
+
+// publishAlert sends the audit summary to the SNS alert topic
+func publishAlert(sess *session.Session, topicArn string, summary string) error {
+	_, err := sns.New(sess).Publish(&sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String("Token holder audit failed"),
+		Message:  aws.String(summary),
+	})
+	return err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeTokenHolder stands in for the token holder contract binding
+type fakeTokenHolder struct {
+	role       [32]byte
+	holders    map[common.Address]bool
+	roleErr    error
+	hasRoleErr error
+	voucherGas uint64
+	sellErr    error
+}
+
+func (f *fakeTokenHolder) PROCESSORROLE(opts *bind.CallOpts) ([32]byte, error) {
+	return f.role, f.roleErr
+}
+
+func (f *fakeTokenHolder) HasRole(opts *bind.CallOpts, role [32]byte, account common.Address) (bool, error) {
+	if f.hasRoleErr != nil {
+		return false, f.hasRoleErr
+	}
+	return role == f.role && f.holders[account], nil
+}
+
+// voucherTxn mimics the binding, which estimates gas before handing the txn to the signer
+func (f *fakeTokenHolder) voucherTxn(opts *bind.TransactOpts, err error) (*ethtypes.Transaction, error) {
+	if err != nil {
+		return nil, err
+	}
+	tx := ethtypes.NewTransaction(0, common.Address{}, big.NewInt(0), f.voucherGas, opts.GasPrice, nil)
+	return opts.Signer(ethtypes.HomesteadSigner{}, opts.From, tx)
+}
+
+func (f *fakeTokenHolder) SellVouchers(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+	return f.voucherTxn(opts, f.sellErr)
+}
+
+func (f *fakeTokenHolder) BuyVouchers(opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+	return f.voucherTxn(opts, nil)
+}
+
+// failingBackend makes selected simulated backend calls fail
+type failingBackend struct {
+	*backends.SimulatedBackend
+	balanceErr  error
+	gasPriceErr error
+}
+
+func (b *failingBackend) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	if b.balanceErr != nil {
+		return nil, b.balanceErr
+	}
+	return b.SimulatedBackend.BalanceAt(ctx, account, blockNumber)
+}
+
+func (b *failingBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	if b.gasPriceErr != nil {
+		return nil, b.gasPriceErr
+	}
+	return b.SimulatedBackend.SuggestGasPrice(ctx)
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	return key
+}
+
+func newBackend(key *ecdsa.PrivateKey, balance *big.Int) *backends.SimulatedBackend {
+	alloc := core.GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): core.GenesisAccount{Balance: balance}}
+	return backends.NewSimulatedBackend(alloc, 8000000)
+}
+
+func expectFailure(t *testing.T, name string, report *auditReport, want string) {
+	if want == "" {
+		if len(report.failures) != 0 {
+			t.Errorf("%s: expected no failures, got %v", name, report.failures)
+		}
+		return
+	}
+	if len(report.failures) != 1 || !strings.Contains(report.failures[0], want) {
+		t.Errorf("%s: expected one failure containing %q, got %v", name, want, report.failures)
+	}
+}
+
+func TestIsAuditEvent(t *testing.T) {
+	event := events.CloudWatchEvent{Resources: []string{"arn:aws:events:us-east-1:123456789012:rule/tokenholder-audit"}}
+	tests := []struct {
+		name     string
+		ruleName string
+		want     bool
+	}{
+		{"matching rule", "tokenholder-audit", true},
+		{"other rule", "tokenholder", false},
+		{"audit disabled", "", false},
+	}
+	for _, test := range tests {
+		if got := isAuditEvent(event, test.ruleName); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReportAudit(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	failed := &auditReport{}
+	failed.fail("first %s", "issue")
+	failed.fail("second issue")
+
+	wantSummary := "Token holder audit at " + at.String() + " found 2 issue(s):\n- first issue\n- second issue"
+	if got := failed.summary(at); got != wantSummary {
+		t.Errorf("got summary %q, want %q", got, wantSummary)
+	}
+
+	tests := []struct {
+		name       string
+		report     *auditReport
+		topicArn   string
+		publishErr error
+		published  bool
+		wantErr    bool
+	}{
+		{"passed", &auditReport{}, "arn:topic", nil, false, false},
+		{"alert published", failed, "arn:topic", nil, true, false},
+		{"no alert topic", failed, "", nil, false, true},
+		{"publish fails", failed, "arn:topic", errors.New("access denied"), true, true},
+	}
+	for _, test := range tests {
+		published := false
+		_, err := reportAudit(test.report, at, test.topicArn, func(topicArn, summary string) error {
+			published = true
+			if summary != wantSummary {
+				t.Errorf("%s: published summary %q, want %q", test.name, summary, wantSummary)
+			}
+			return test.publishErr
+		})
+		if published != test.published {
+			t.Errorf("%s: got published %v, want %v", test.name, published, test.published)
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestAuditVaultKey(t *testing.T) {
+	key := newKey(t)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	backend := newBackend(key, big.NewInt(1))
+
+	tests := []struct {
+		name     string
+		backend  auditBackend
+		expected common.Address
+		want     string
+	}{
+		{"healthy", backend, address, ""},
+		{"address drift", backend, common.HexToAddress("0x01"), "instead of expected address"},
+		{"balance fails", &failingBackend{SimulatedBackend: backend, balanceErr: errors.New("rpc down")}, address, "Failed to get balance for vault"},
+	}
+	for _, test := range tests {
+		report := &auditReport{}
+		auditVaultKey(context.Background(), test.backend, key, test.expected, report)
+		expectFailure(t, test.name, report, test.want)
+	}
+}
+
+func TestAuditProcessingKey(t *testing.T) {
+	ctx := context.Background()
+	key := newKey(t)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	role := crypto.Keccak256Hash([]byte("PROCESSOR_ROLE"))
+	voucherGas := uint64(120000)
+
+	funded := newBackend(key, big.NewInt(1e18))
+	gasPrice, err := funded.SuggestGasPrice(ctx)
+	if err != nil {
+		t.Fatalf("failed to get gas price: %s", err)
+	}
+	required := new(big.Int).Mul(new(big.Int).SetUint64(voucherGas*2), gasPrice)
+	underfunded := newBackend(key, new(big.Int).Sub(required, big.NewInt(1)))
+
+	healthy := func() *fakeTokenHolder {
+		return &fakeTokenHolder{role: role, holders: map[common.Address]bool{address: true}, voucherGas: voucherGas}
+	}
+	revoked := healthy()
+	revoked.holders = nil
+	roleErr := healthy()
+	roleErr.roleErr = errors.New("reverted")
+	hasRoleErr := healthy()
+	hasRoleErr.hasRoleErr = errors.New("rpc down")
+	sellReverts := healthy()
+	sellReverts.sellErr = errors.New("failed to estimate gas needed: execution reverted")
+
+	tests := []struct {
+		name     string
+		backend  auditBackend
+		contract *fakeTokenHolder
+		want     string
+	}{
+		{"healthy", funded, healthy(), ""},
+		{"role revoked", funded, revoked, "no longer holds processor role"},
+		{"role getter fails", funded, roleErr, "Failed to get processor role"},
+		{"has role fails", funded, hasRoleErr, "Failed to check processor role"},
+		{"sell reverts", funded, sellReverts, "Sell vouchers from processing key"},
+		{"gas price fails", &failingBackend{SimulatedBackend: funded, gasPriceErr: errors.New("rpc down")}, healthy(), "Failed to get gas price"},
+		{"balance fails", &failingBackend{SimulatedBackend: funded, balanceErr: errors.New("rpc down")}, healthy(), "Failed to get balance for processing key"},
+		{"not enough gas", underfunded, healthy(), "is below estimated sell and buy vouchers txn fees"},
+	}
+	for _, test := range tests {
+		report := &auditReport{}
+		auditProcessingKey(ctx, test.backend, test.contract, key, report)
+		expectFailure(t, test.name, report, test.want)
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Audit settings, loaded from SSM alongside the other settings
+//
+// AUDIT_RULE_NAME is the name of the scheduled CloudWatch rule that triggers
+// the audit instead of the token holder event. Audit is disabled when unset.
+//
+// EXPECTED_VAULT_ADDRESS (required by the audit) is the address the vault
+// private key must control.
+//
+// AUDIT_ALERT_TOPIC_ARN (optional) is the SNS topic audit failures are published to.
+var (
+	ExpectedVaultAddress string
+	AuditAlertTopicARN   string
+)
+
+// AuditRuleName returns the name of the CloudWatch rule that triggers the audit
+func AuditRuleName() string {
+	return os.Getenv("AUDIT_RULE_NAME")
+}
+
+// ParseAudit parses the environment variables needed by the audit
+func ParseAudit() error {
+	AuditAlertTopicARN = os.Getenv("AUDIT_ALERT_TOPIC_ARN")
+
+	ExpectedVaultAddress = os.Getenv("EXPECTED_VAULT_ADDRESS")
+	if ExpectedVaultAddress == "" {
+		return fmt.Errorf("EXPECTED_VAULT_ADDRESS is not set")
+	}
+	if !common.IsHexAddress(ExpectedVaultAddress) {
+		return fmt.Errorf("EXPECTED_VAULT_ADDRESS %q is not a valid address", ExpectedVaultAddress)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+// setEnv sets an environment variable and returns a func restoring its previous value
+func setEnv(key, value string) func() {
+	previous, found := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if found {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestParseAudit(t *testing.T) {
+	defer setEnv("AUDIT_ALERT_TOPIC_ARN", "arn:aws:sns:us-east-1:123456789012:audit")()
+
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"", true},
+		{"not-an-address", true},
+		{"0x8a3f6c2a7a0b6d6e3a3b0d0f3e8f0b7c1d2e3f40", false},
+	}
+	for _, test := range tests {
+		restore := setEnv("EXPECTED_VAULT_ADDRESS", test.address)
+		err := ParseAudit()
+		restore()
+		if (err != nil) != test.wantErr {
+			t.Errorf("EXPECTED_VAULT_ADDRESS=%q: got error %v, want error %v", test.address, err, test.wantErr)
+		}
+	}
+	if AuditAlertTopicARN != "arn:aws:sns:us-east-1:123456789012:audit" {
+		t.Errorf("got alert topic %q", AuditAlertTopicARN)
+	}
+}
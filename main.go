@@ -39,15 +39,12 @@ func init() {
 			log.Printf("error: failed to create new session %s\n", err)
 			os.Exit(1)
 		}
-		service := ssm.New(session)
-		withDecryption := true
-		request := ssm.GetParametersByPathInput{Path: &path, WithDecryption: &withDecryption}
-		response, err := service.GetParametersByPath(&request)
+		parameters, err := getParameters(session, path)
 		if err != nil {
 			log.Printf("error: failed to get parameters, %s\n", err)
 			os.Exit(1)
 		}
-		for _, parameter := range response.Parameters {
+		for _, parameter := range parameters {
 			paramName := strings.ToUpper(strings.TrimPrefix(*parameter.Name, path))
 			os.Setenv(paramName, *parameter.Value)
 			log.Printf("set env variable: %s\n", paramName)
@@ -55,8 +52,25 @@ func init() {
 	}
 }
 
+// getParameters fetches and decrypts all SSM parameters stored under path
+func getParameters(sess *session.Session, path string) ([]*ssm.Parameter, error) {
+	service := ssm.New(sess)
+	withDecryption := true
+	request := ssm.GetParametersByPathInput{Path: &path, WithDecryption: &withDecryption}
+	response, err := service.GetParametersByPath(&request)
+	if err != nil {
+		return nil, err
+	}
+	return response.Parameters, nil
+}
+
 // HandleEvent will handle the event that triggered Lambda function
 func HandleEvent(ctx context.Context, event events.CloudWatchEvent) (string, error) {
+	// Audit rule only verifies keys and permissions, no funds are moved
+	if isAuditEvent(event, config.AuditRuleName()) {
+		return HandleAudit(ctx, event)
+	}
+
 	err := config.Parse()
 	if err != nil {
 		return formatError("Unable to parse environment variables with error : %s", err)